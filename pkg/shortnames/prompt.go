@@ -0,0 +1,54 @@
+package shortnames
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/pkg/errors"
+)
+
+// terminalPrompter is the default Prompter: it writes the candidates to
+// stdout and reads the user's choice from stdin.
+type terminalPrompter struct {
+	out io.Writer
+	in  *bufio.Reader
+}
+
+// NewTerminalPrompter returns the default Prompter for interactive
+// terminals, prompting on stdout/stdin.
+func NewTerminalPrompter() Prompter {
+	return &terminalPrompter{out: os.Stdout, in: bufio.NewReader(os.Stdin)}
+}
+
+// Ask implements Prompter.
+func (t *terminalPrompter) Ask(_ context.Context, name string, candidates []reference.Named) (int, bool, error) {
+	fmt.Fprintf(t.out, "%q is not aliased; please choose one of the following registries to pull from:\n", name)
+	for i, candidate := range candidates {
+		fmt.Fprintf(t.out, "  %d) %s\n", i, candidate.String())
+	}
+	fmt.Fprint(t.out, "Enter number: ")
+
+	line, err := t.in.ReadString('\n')
+	if err != nil {
+		return 0, false, errors.Wrap(err, "error reading choice")
+	}
+	chosen, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid choice %q", line)
+	}
+
+	fmt.Fprint(t.out, "Remember this choice for future pulls (yes/no)? ")
+	line, err = t.in.ReadString('\n')
+	if err != nil {
+		return 0, false, errors.Wrap(err, "error reading remember choice")
+	}
+	remember := strings.EqualFold(strings.TrimSpace(line), "yes")
+
+	return chosen, remember, nil
+}
@@ -0,0 +1,53 @@
+package shortnames
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func candidateNamed(t *testing.T, s string) reference.Named {
+	named, err := reference.ParseNormalizedNamed(s)
+	require.NoError(t, err)
+	return named
+}
+
+func TestTerminalPrompterAskParsesChoiceAndRemember(t *testing.T) {
+	candidates := []reference.Named{
+		candidateNamed(t, "registry1.example.com/repo"),
+		candidateNamed(t, "registry2.example.com/repo"),
+	}
+	var out bytes.Buffer
+	p := &terminalPrompter{out: &out, in: bufio.NewReader(strings.NewReader("1\nyes\n"))}
+
+	chosen, remember, err := p.Ask(context.Background(), "repo", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chosen)
+	assert.True(t, remember)
+	assert.Contains(t, out.String(), "registry1.example.com/repo")
+	assert.Contains(t, out.String(), "registry2.example.com/repo")
+}
+
+func TestTerminalPrompterAskDefaultsToNotRemembering(t *testing.T) {
+	candidates := []reference.Named{candidateNamed(t, "registry1.example.com/repo")}
+	p := &terminalPrompter{out: &bytes.Buffer{}, in: bufio.NewReader(strings.NewReader("0\n\n"))}
+
+	chosen, remember, err := p.Ask(context.Background(), "repo", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chosen)
+	assert.False(t, remember)
+}
+
+func TestTerminalPrompterAskInvalidChoiceErrors(t *testing.T) {
+	candidates := []reference.Named{candidateNamed(t, "registry1.example.com/repo")}
+	p := &terminalPrompter{out: &bytes.Buffer{}, in: bufio.NewReader(strings.NewReader("not-a-number\n"))}
+
+	_, _, err := p.Ask(context.Background(), "repo", candidates)
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+// Package shortnames implements the user-facing policy around short-name
+// resolution.  It is layered on top of pkg/sysregistriesv2, which only
+// knows how to resolve a short name against the aliases it has on disk; this
+// package adds the interactive behavior that podman, buildah and skopeo
+// would otherwise each have to reimplement on their own.
+package shortnames
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// Prompter lets a caller surface the candidate fully-qualified references
+// for an unqualified short name to the user and learn which one, if any,
+// they picked.  Implementations are expected to number `candidates`
+// starting at 0 and return that index as `chosen`.
+type Prompter interface {
+	// Ask presents name and its candidates to the user and returns the
+	// index of the chosen candidate, whether the choice should be
+	// remembered as a new alias, and an error.
+	Ask(ctx context.Context, name string, candidates []reference.Named) (chosen int, remember bool, err error)
+}
+
+// NoopPrompter is a Prompter for non-TTY use: it never prompts and instead
+// falls back to the same behavior as types.ShortNameModeEnforcing, i.e. it
+// always returns an error.
+type NoopPrompter struct{}
+
+// Ask implements Prompter by refusing to resolve the short name.
+func (NoopPrompter) Ask(_ context.Context, name string, candidates []reference.Named) (int, bool, error) {
+	return 0, false, errors.Errorf("short-name %q did not resolve to an alias and no prompter is available to disambiguate among %d candidates", name, len(candidates))
+}
+
+// Resolved is the outcome of resolving a short name: either an existing
+// alias or a reference chosen interactively.
+type Resolved struct {
+	// The resolved, fully-qualified reference.
+	Reference reference.Named
+	// A human-readable description of where Reference came from,
+	// e.g. the path of the config file it's aliased in, or "prompt" if
+	// it was chosen interactively and not remembered.
+	Description string
+}
+
+// Resolve resolves the specified short `name` according to ctx's
+// ShortNameMode.  If no alias exists for `name` and the mode is
+// types.ShortNameModeInteractive, the ordered unqualified-search-registries
+// of the assembled registries.conf are turned into candidate references and
+// handed to `prompter`.  If the user's choice should be remembered,
+// it is persisted via sysregistriesv2.AddShortNameAlias.
+//
+// Note that it's the caller's responsibility to pass only a repository
+// (reference.IsNameOnly) as the short name.
+func Resolve(ctx context.Context, sysCtx *types.SystemContext, name string, prompter Prompter) (*Resolved, error) {
+	named, origin, err := sysregistriesv2.ResolveShortNameAlias(sysCtx, name)
+	if err != nil {
+		return nil, err
+	}
+	if named != nil {
+		return &Resolved{Reference: named, Description: origin}, nil
+	}
+
+	mode, err := sysregistriesv2.ShortNameMode(sysCtx)
+	if err != nil {
+		return nil, err
+	}
+	if mode != types.ShortNameModeInteractive {
+		// Fall back to the existing Permissive/Disabled/Enforcing
+		// semantics implemented by callers of ResolveShortNameAlias.
+		return nil, nil
+	}
+
+	searchRegistries, err := sysregistriesv2.UnqualifiedSearchRegistries(sysCtx)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]reference.Named, 0, len(searchRegistries))
+	for _, registry := range searchRegistries {
+		candidate, err := reference.ParseNormalizedNamed(registry + "/" + name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error generating candidate for short name %q on %q", name, registry)
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("short-name %q did not resolve to an alias and no unqualified-search-registries are configured", name)
+	}
+
+	if prompter == nil {
+		prompter = NoopPrompter{}
+	}
+	chosen, remember, err := prompter.Ask(ctx, name, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if chosen < 0 || chosen >= len(candidates) {
+		return nil, errors.Errorf("invalid choice %d for short name %q: must be in [0,%d)", chosen, name, len(candidates))
+	}
+	picked := candidates[chosen]
+
+	if remember {
+		if err := sysregistriesv2.AddShortNameAlias(sysCtx, name, picked.String()); err != nil {
+			return nil, err
+		}
+		return &Resolved{Reference: picked, Description: "newly created alias"}, nil
+	}
+	return &Resolved{Reference: picked, Description: "prompt"}, nil
+}
@@ -0,0 +1,116 @@
+package shortnames
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPrompter is a Prompter whose answer is fixed in advance, for
+// exercising Resolve's interactive branch without a real terminal.
+type stubPrompter struct {
+	chosen   int
+	remember bool
+	err      error
+}
+
+func (s stubPrompter) Ask(_ context.Context, _ string, _ []reference.Named) (int, bool, error) {
+	return s.chosen, s.remember, s.err
+}
+
+// newTestSystemContext returns a SystemContext pointing entirely at fresh
+// files/directories under t.TempDir(), so tests never touch the real
+// /etc/containers config and don't collide with one another's
+// sysregistriesv2 config cache entry (keyed by SystemRegistriesConfPath).
+func newTestSystemContext(t *testing.T, registriesConf string) *types.SystemContext {
+	dir := t.TempDir()
+
+	registriesPath := filepath.Join(dir, "registries.conf")
+	require.NoError(t, ioutil.WriteFile(registriesPath, []byte(registriesConf), 0600))
+
+	dropInDir := filepath.Join(dir, "registries.conf.d")
+	require.NoError(t, os.MkdirAll(dropInDir, 0700))
+
+	return &types.SystemContext{
+		SystemRegistriesConfPath:    registriesPath,
+		SystemRegistriesConfDirPath: dropInDir,
+		UserShortNameAliasConfPath:  filepath.Join(dir, "short-name-aliases.conf"),
+	}
+}
+
+func TestResolveReturnsAliasHit(t *testing.T) {
+	sysCtx := newTestSystemContext(t, "")
+	require.NoError(t, ioutil.WriteFile(sysCtx.UserShortNameAliasConfPath,
+		[]byte("[aliases]\n  \"foo\" = \"registry.example.com/foo\"\n"), 0600))
+
+	resolved, err := Resolve(context.Background(), sysCtx, "foo", nil)
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	assert.Equal(t, "registry.example.com/foo", resolved.Reference.String())
+	assert.Equal(t, sysCtx.UserShortNameAliasConfPath, resolved.Description)
+}
+
+func TestResolveNoAliasNonInteractiveReturnsNil(t *testing.T) {
+	// No short-name-mode set defaults to permissive, i.e. not
+	// interactive: Resolve must defer to the caller's existing
+	// Permissive/Disabled/Enforcing handling instead of prompting.
+	sysCtx := newTestSystemContext(t, "")
+
+	resolved, err := Resolve(context.Background(), sysCtx, "foo", stubPrompter{})
+	require.NoError(t, err)
+	assert.Nil(t, resolved)
+}
+
+func TestResolveInteractiveRemembersChoice(t *testing.T) {
+	sysCtx := newTestSystemContext(t, `short-name-mode = "interactive"
+unqualified-search-registries = ["registry1.example.com", "registry2.example.com"]
+`)
+
+	resolved, err := Resolve(context.Background(), sysCtx, "foo", stubPrompter{chosen: 1, remember: true})
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	assert.Equal(t, "registry2.example.com/foo", resolved.Reference.String())
+	assert.Equal(t, "newly created alias", resolved.Description)
+
+	// The choice must actually have been persisted as a new alias.
+	data, err := ioutil.ReadFile(sysCtx.UserShortNameAliasConfPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "registry2.example.com/foo")
+}
+
+func TestResolveInteractiveWithoutRemembering(t *testing.T) {
+	sysCtx := newTestSystemContext(t, `short-name-mode = "interactive"
+unqualified-search-registries = ["registry1.example.com"]
+`)
+
+	resolved, err := Resolve(context.Background(), sysCtx, "foo", stubPrompter{chosen: 0, remember: false})
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	assert.Equal(t, "registry1.example.com/foo", resolved.Reference.String())
+	assert.Equal(t, "prompt", resolved.Description)
+
+	_, err = os.Stat(sysCtx.UserShortNameAliasConfPath)
+	assert.True(t, os.IsNotExist(err), "no alias should have been written")
+}
+
+func TestResolveInteractiveOutOfRangeChoiceErrors(t *testing.T) {
+	sysCtx := newTestSystemContext(t, `short-name-mode = "interactive"
+unqualified-search-registries = ["registry1.example.com"]
+`)
+
+	_, err := Resolve(context.Background(), sysCtx, "foo", stubPrompter{chosen: 5})
+	assert.Error(t, err)
+}
+
+func TestNoopPrompterAskReturnsError(t *testing.T) {
+	candidates := []reference.Named{}
+	_, _, err := NoopPrompter{}.Ask(context.Background(), "foo", candidates)
+	assert.Error(t, err)
+}
@@ -1,9 +1,14 @@
 package sysregistriesv2
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 	"github.com/containers/image/v5/docker/reference"
@@ -13,6 +18,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// regexShortNamePrefix is the prefix that marks the left-hand side of an
+// alias as a regular expression instead of a literal short name.
+const regexShortNamePrefix = "re:"
+
 // defaultShortNameMode is the default mode of registries.conf files if the
 // corresponding field is left empty.
 const defaultShortNameMode = types.ShortNameModePermissive
@@ -45,6 +54,109 @@ type alias struct {
 	configOrigin string
 }
 
+// regexAlias combines a compiled regular-expression alias pattern with the
+// (unexpanded) right-hand side value and the config file it originates
+// from.  Unlike the exact-match entries in namedAliases, the value is
+// expanded with the pattern's captures at resolution time.
+type regexAlias struct {
+	// The pattern with the "re:" prefix stripped off.
+	pattern string
+	// The compiled pattern, built once in parseAndValidate.
+	regex *regexp.Regexp
+	// The unexpanded right-hand side, e.g. "example.com/$1/$2".
+	value string
+	// The config file the alias originates from.
+	configOrigin string
+	// True if this entry came from an enabled [[alias-set]] rather than
+	// the flat [aliases] map.  Used by sortRegexAliases to keep regex
+	// precedence consistent with namedAliases': an alias-set overrides a
+	// same-ranked flat entry.
+	fromAliasSet bool
+	// The order in which this entry was parsed, relative to every other
+	// regexAlias built in this process.  Used by sortRegexAliases only to
+	// order entries with identical patterns (and equal fromAliasSet),
+	// which come from different alias-sets or config files: the
+	// later-parsed one comes first, just as a later entry overwrites an
+	// earlier one in namedAliases.
+	seq int64
+}
+
+// regexAliasSeq is the source of regexAlias.seq values; see its docs.
+var regexAliasSeq int64
+
+func nextRegexAliasSeq() int64 {
+	return atomic.AddInt64(&regexAliasSeq, 1)
+}
+
+// sortRegexAliases orders aliases by precedence: longest pattern first;
+// then, for two patterns of equal length, an alias-set entry before a flat
+// [aliases] one, mirroring namedAliases' documented "alias-set overrides
+// flat map" precedence; then by pattern string, so the order does not
+// depend on the randomized iteration order of the Aliases/AliasSets maps
+// the entries were parsed from and resolution is reproducible across runs;
+// and, for identical patterns, the later-parsed entry (from a later
+// alias-set or a later drop-in) first.
+func sortRegexAliases(aliases []regexAlias) {
+	sort.Slice(aliases, func(i, j int) bool {
+		if len(aliases[i].pattern) != len(aliases[j].pattern) {
+			return len(aliases[i].pattern) > len(aliases[j].pattern)
+		}
+		if aliases[i].fromAliasSet != aliases[j].fromAliasSet {
+			return aliases[i].fromAliasSet
+		}
+		if aliases[i].pattern != aliases[j].pattern {
+			return aliases[i].pattern < aliases[j].pattern
+		}
+		return aliases[i].seq > aliases[j].seq
+	})
+}
+
+// aliasSetPredicate gates whether an [[alias-set]] is in effect.  Exactly
+// one of its fields is expected to be set; an empty predicate is always
+// enabled.
+type aliasSetPredicate struct {
+	// A "NAME=value" pair; the set is enabled iff the environment
+	// variable NAME is set to value.
+	Env string `toml:"env,omitempty"`
+	// A glob (as in path/filepath.Match) matched against os.Hostname().
+	HostnameGlob string `toml:"hostname-glob,omitempty"`
+}
+
+// evaluate reports whether p's condition holds in the current process.
+func (p aliasSetPredicate) evaluate() (bool, error) {
+	if p.Env != "" {
+		parts := strings.SplitN(p.Env, "=", 2)
+		if len(parts) != 2 {
+			return false, errors.Errorf("invalid 'env' predicate %q: expected NAME=value", p.Env)
+		}
+		return os.Getenv(parts[0]) == parts[1], nil
+	}
+	if p.HostnameGlob != "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return false, err
+		}
+		return filepath.Match(p.HostnameGlob, hostname)
+	}
+	return true, nil
+}
+
+// aliasSet is a named, conditionally-enabled group of aliases, configured
+// via `[[alias-set]]` in short-name-aliases.conf or registries.conf.  It
+// lets multi-tenant hosts ship one registries.conf that behaves
+// differently per user/project without templating.
+type aliasSet struct {
+	// The name of the set, surfaced in error messages and origins so
+	// users can tell which alias-set resolved a given short name.
+	Name string `toml:"name"`
+	// The condition under which this set's aliases are considered. An
+	// empty predicate is always enabled.
+	EnabledWhen aliasSetPredicate `toml:"enabled-when"`
+	// The aliases contributed by this set, using the same syntax
+	// (including "re:" regex entries) as the flat [aliases] map.
+	Aliases map[string]string `toml:"aliases"`
+}
+
 // shortNameAliasConf is a subset of the `V2RegistriesConf` format.  It's used in the
 // software-maintained `userShortNamesFile`.
 type shortNameAliasConf struct {
@@ -53,18 +165,33 @@ type shortNameAliasConf struct {
 	// Note that Aliases is niled after being loaded from a file.
 	Aliases map[string]string `toml:"aliases"`
 
+	// Named, conditionally-enabled groups of aliases.  Evaluated, in
+	// order, after the flat Aliases map above, so an enabled set
+	// overrides a same-named entry there.
+	AliasSets []aliasSet `toml:"alias-set"`
+
 	// Note that an alias value may be nil iff it's set as an empty string
-	// in the config.
+	// in the config.  Populated from both Aliases and AliasSets (those
+	// whose EnabledWhen evaluates to true), in that order, so an enabled
+	// alias-set takes precedence over the flat map.
 	namedAliases map[string]alias
+
+	// Compiled "re:"-prefixed entries of Aliases and of the enabled
+	// AliasSets, in the order they should be matched in, as set by
+	// sortRegexAliases (longest pattern first).
+	regexAliases []regexAlias
 }
 
 // ResolveShortNameAlias performs an alias resolution of the specified name.
 // The user-specific short-name-aliases.conf has precedence over aliases in the
-// assembled registries.conf.  It returns the possibly resolved alias or nil, a
-// human-readable description of the config where the alias is specified, and
-// an error. The origin of the config file is crucial for an improved user
-// experience such that users are able to resolve potential pull errors.
-// Almost all callers should use pkg/shortnames instead.
+// assembled registries.conf.  Within each of the two, exact matches are
+// tried first; if none is found, its "re:"-prefixed regular-expression
+// aliases are matched in order (longest pattern first) and, on a match,
+// their captures are expanded into the right-hand side value.  It returns the possibly resolved alias or
+// nil, a human-readable description of the config where the alias is
+// specified, and an error. The origin of the config file is crucial for an
+// improved user experience such that users are able to resolve potential
+// pull errors. Almost all callers should use pkg/shortnames instead.
 //
 // Note that it’s the caller’s responsibility to pass only a repository
 // (reference.IsNameOnly) as the short name.
@@ -93,6 +220,10 @@ func ResolveShortNameAlias(ctx *types.SystemContext, name string) (reference.Nam
 	if resolved {
 		return alias.value, alias.configOrigin, nil
 	}
+	named, origin, resolved, err := resolveRegexAlias(aliasConf.regexAliases, name)
+	if err != nil || resolved {
+		return named, origin, err
+	}
 
 	config, err := getConfig(ctx)
 	if err != nil {
@@ -102,13 +233,39 @@ func ResolveShortNameAlias(ctx *types.SystemContext, name string) (reference.Nam
 	if resolved {
 		return alias.value, alias.configOrigin, nil
 	}
-	return nil, "", nil
+	named, origin, _, err = resolveRegexAlias(config.regexAliases, name)
+	return named, origin, err
+}
+
+// resolveRegexAlias iterates regexAliases (already sorted by precedence by
+// sortRegexAliases) and, on the first match, expands the matched captures
+// into the right-hand side value and validates the result via
+// parseShortNameValue.  It takes the slice directly, rather than either of
+// the two conf types that own one, so it can serve both
+// shortNameAliasConf.regexAliases and V2RegistriesConf.regexAliases.
+func resolveRegexAlias(regexAliases []regexAlias, name string) (reference.Named, string, bool, error) {
+	for _, ra := range regexAliases {
+		loc := ra.regex.FindStringSubmatchIndex(name)
+		if loc == nil {
+			continue
+		}
+		expanded := ra.regex.ExpandString(nil, ra.value, name, loc)
+		named, err := parseShortNameValue(string(expanded))
+		if err != nil {
+			return nil, "", true, errors.Wrapf(err, "error expanding regex alias %q in %q", ra.pattern, ra.configOrigin)
+		}
+		return named, ra.configOrigin, true, nil
+	}
+	return nil, "", false, nil
 }
 
 // editShortNameAlias loads the aliases.conf file and changes it. If value is
 // set, it adds the name-value pair as a new alias. Otherwise, it will remove
-// name from the config.
-func editShortNameAlias(ctx *types.SystemContext, name string, value *string) error {
+// name from the config.  An empty scopeName edits the default, unscoped
+// [aliases] table, matching the pre-existing behavior; a non-empty
+// scopeName edits the named [[alias-set]]'s table instead, creating it if
+// it doesn't already exist.
+func editShortNameAlias(ctx *types.SystemContext, name string, value *string, scopeName string) error {
 	if err := validateShortName(name); err != nil {
 		return err
 	}
@@ -134,25 +291,206 @@ func editShortNameAlias(ctx *types.SystemContext, name string, value *string) er
 		return err
 	}
 
+	aliases := conf.Aliases
+	if scopeName != "" {
+		set := conf.findOrCreateAliasSet(scopeName)
+		aliases = set.Aliases
+	}
+
 	if value != nil {
-		conf.Aliases[name] = *value
+		aliases[name] = *value
 	} else {
 		// If the name does not exist, throw an error.
-		if _, exists := conf.Aliases[name]; !exists {
+		if _, exists := aliases[name]; !exists {
 			return errors.Errorf("short-name alias %q not found in %q: please check registries.conf files", name, confPath)
 		}
 
-		delete(conf.Aliases, name)
+		delete(aliases, name)
 	}
 
-	f, err := os.OpenFile(confPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	return writeShortNameAliasConf(confPath, conf)
+}
+
+// journalSuffix and tmpSuffix name the on-disk helper files used by
+// writeShortNameAliasConf/recoverShortNameAliasConf to make writes to
+// confPath atomic.
+const (
+	journalSuffix = ".journal"
+	tmpSuffix     = ".tmp"
+)
+
+// tmpCompletionMarker is appended, as a TOML comment, after conf has been
+// fully encoded into the temporary file.  A process killed mid-Encode (or
+// mid-write of the marker itself) leaves a file that may still happen to be
+// syntactically valid TOML -- e.g. a prefix made up of whole key/value
+// lines -- so "does it parse" alone is not sufficient evidence that the
+// write completed; recovery additionally requires the file to end in this
+// exact marker.
+const tmpCompletionMarker = "# short-name-aliases.conf write complete\n"
+
+// fileOps abstracts the handful of filesystem operations that
+// writeShortNameAliasConf performs in sequence, letting tests inject
+// faults between any two of them to simulate a crash mid-write.
+type fileOps interface {
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	Create(path string) (*os.File, error)
+	Sync(f *os.File) error
+	SyncDir(dir string) error
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+}
+
+// defaultFileOps is the real, unwrapped implementation of fileOps used in
+// production; tests substitute currentFileOps with a fault-injecting one.
+type defaultFileOps struct{}
+
+func (defaultFileOps) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+func (defaultFileOps) Create(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+func (defaultFileOps) Sync(f *os.File) error { return f.Sync() }
+
+func (defaultFileOps) SyncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer d.Close()
+	return d.Sync()
+}
+
+func (defaultFileOps) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+func (defaultFileOps) Remove(path string) error { return os.Remove(path) }
+
+// currentFileOps is the fileOps implementation used by
+// writeShortNameAliasConf/recoverShortNameAliasConf.  Only tests in this
+// package ever change it.
+var currentFileOps fileOps = defaultFileOps{}
+
+// writeShortNameAliasConf durably persists conf to confPath.  Instead of
+// truncating confPath in place (which could leave an empty, unreadable
+// aliases file behind if the process is killed between the truncation and
+// the encode), it writes a journal marking the write as in-progress,
+// encodes conf into a sibling "*.tmp" file, fsyncs that file and its
+// parent directory, and only then renames it over confPath -- at which
+// point the journal is removed.  recoverShortNameAliasConf knows how to
+// finish or discard an interrupted write by inspecting a leftover journal.
+func writeShortNameAliasConf(confPath string, conf *shortNameAliasConf) error {
+	journalPath := confPath + journalSuffix
+	tmpPath := confPath + tmpSuffix
+
+	if err := currentFileOps.WriteFile(journalPath, []byte("short-name-aliases.conf write in progress\n"), 0600); err != nil {
+		return errors.Wrapf(err, "error creating journal %q", journalPath)
+	}
+
+	f, err := currentFileOps.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "error creating temporary file %q", tmpPath)
+	}
+	if err := toml.NewEncoder(f).Encode(conf); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error encoding %q", tmpPath)
+	}
+	if _, err := f.WriteString(tmpCompletionMarker); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error finalizing %q", tmpPath)
+	}
+	if err := currentFileOps.Sync(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error syncing %q", tmpPath)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := currentFileOps.SyncDir(filepath.Dir(confPath)); err != nil {
+		return errors.Wrapf(err, "error syncing %q", filepath.Dir(confPath))
+	}
+
+	if err := currentFileOps.Rename(tmpPath, confPath); err != nil {
+		return errors.Wrapf(err, "error renaming %q to %q", tmpPath, confPath)
+	}
+
+	return currentFileOps.Remove(journalPath)
+}
+
+// recoverShortNameAliasConf detects a journal left behind by a process
+// that crashed mid-write and either completes or discards the interrupted
+// write, so that a subsequent load always sees either the old or the new
+// (but never a truncated) confPath.  It must be called under the writer
+// lock, which is what shortNameAliasesConfPathAndLock does the first time
+// it's invoked for a given confPath in a process.
+func recoverShortNameAliasConf(confPath string) error {
+	journalPath := confPath + journalSuffix
+	if _, err := os.Stat(journalPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
 
-	encoder := toml.NewEncoder(f)
-	return encoder.Encode(conf)
+	tmpPath := confPath + tmpSuffix
+	if tmpWriteIsComplete(tmpPath) {
+		// The temporary file is complete: the crash happened after
+		// the completion marker was written but before (or during)
+		// the rename, so it's safe to finish what
+		// writeShortNameAliasConf started.
+		if err := currentFileOps.Rename(tmpPath, confPath); err != nil {
+			return errors.Wrapf(err, "error completing interrupted write of %q", confPath)
+		}
+	} else {
+		// The temporary file is missing, malformed, or was only
+		// partially written: the crash happened before the fsync
+		// made it durable, so confPath (untouched by
+		// writeShortNameAliasConf) is still the best copy we have.
+		//
+		// Note that a truncated write can still happen to be
+		// syntactically valid TOML (e.g. a prefix made up of whole
+		// key/value lines), so checking for tmpCompletionMarker,
+		// rather than merely that the file parses, is what actually
+		// distinguishes a complete write from a partial one.
+		_ = currentFileOps.Remove(tmpPath)
+	}
+
+	return currentFileOps.Remove(journalPath)
+}
+
+// tmpWriteIsComplete reports whether tmpPath both parses as valid TOML and
+// ends in tmpCompletionMarker, i.e. writeShortNameAliasConf ran to
+// completion (including the final marker write) before the crash.
+func tmpWriteIsComplete(tmpPath string) bool {
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return false
+	}
+	if !strings.HasSuffix(string(data), tmpCompletionMarker) {
+		return false
+	}
+	_, err = toml.Decode(string(data), &shortNameAliasConf{})
+	return err == nil
+}
+
+// findOrCreateAliasSet returns conf's [[alias-set]] named scopeName,
+// creating an empty, always-enabled one if none exists yet.
+func (conf *shortNameAliasConf) findOrCreateAliasSet(scopeName string) *aliasSet {
+	for i := range conf.AliasSets {
+		if conf.AliasSets[i].Name == scopeName {
+			if conf.AliasSets[i].Aliases == nil {
+				// A hand-written [[alias-set]] may omit the
+				// "aliases" sub-table entirely, leaving this nil
+				// after TOML decoding.
+				conf.AliasSets[i].Aliases = make(map[string]string)
+			}
+			return &conf.AliasSets[i]
+		}
+	}
+	conf.AliasSets = append(conf.AliasSets, aliasSet{Name: scopeName, Aliases: make(map[string]string)})
+	return &conf.AliasSets[len(conf.AliasSets)-1]
 }
 
 // AddShortNameAlias adds the specified name-value pair as a new alias to the
@@ -161,7 +499,17 @@ func editShortNameAlias(ctx *types.SystemContext, name string, value *string) er
 // Note that it’s the caller’s responsibility to pass only a repository
 // (reference.IsNameOnly) as the short name.
 func AddShortNameAlias(ctx *types.SystemContext, name string, value string) error {
-	return editShortNameAlias(ctx, name, &value)
+	return AddShortNameAliasInScope(ctx, name, value, "")
+}
+
+// AddShortNameAliasInScope behaves like AddShortNameAlias, except that a
+// non-empty scopeName writes the alias into the named [[alias-set]] instead
+// of the default, unscoped [aliases] table.
+func AddShortNameAliasInScope(ctx *types.SystemContext, name, value, scopeName string) error {
+	if strings.HasPrefix(name, regexShortNamePrefix) {
+		return errors.Errorf("invalid short name %q: regular-expression aliases must be added by editing registries.conf directly", name)
+	}
+	return editShortNameAlias(ctx, name, &value, scopeName)
 }
 
 // RemoveShortNameAlias clears the alias for the specified name.  It throws an
@@ -172,7 +520,7 @@ func AddShortNameAlias(ctx *types.SystemContext, name string, value string) erro
 // Note that it’s the caller’s responsibility to pass only a repository
 // (reference.IsNameOnly) as the short name.
 func RemoveShortNameAlias(ctx *types.SystemContext, name string) error {
-	return editShortNameAlias(ctx, name, nil)
+	return editShortNameAlias(ctx, name, nil, "")
 }
 
 // parseShortNameValue parses the specified alias into a reference.Named.  The alias is
@@ -238,17 +586,57 @@ func validateShortName(name string) error {
 	return nil
 }
 
-// parseAndValidate parses and validates all entries in conf.Aliases and stores
-// the results in conf.namedAliases.
-func (conf *shortNameAliasConf) parseAndValidate(path string) error {
-	if conf.Aliases == nil {
-		conf.Aliases = make(map[string]string)
+// validateShortNamePattern is the regex counterpart of validateShortName: it
+// checks that `name` carries the "re:" prefix and that the remaining pattern
+// is non-empty.  The pattern itself is compiled separately since a regexp
+// compile error should be reported as such rather than as an invalid short
+// name.
+func validateShortNamePattern(name string) error {
+	if !strings.HasPrefix(name, regexShortNamePrefix) {
+		return errors.Errorf("invalid regex alias %q: must have the %q prefix", name, regexShortNamePrefix)
 	}
-	if conf.namedAliases == nil {
-		conf.namedAliases = make(map[string]alias)
+	if strings.TrimPrefix(name, regexShortNamePrefix) == "" {
+		return errors.Errorf("invalid regex alias %q: empty pattern", name)
 	}
+	return nil
+}
+
+// populateAliases parses and validates the name/value pairs in entries
+// (either a flat [aliases] table or an [[alias-set]]'s Aliases) and stores
+// the results in namedAliases/regexAliases.  origin is the human-readable
+// description to record for each entry's configOrigin; passing a distinct
+// origin per alias-set is how ResolveShortNameAlias is able to tell users
+// which scope resolved a given short name.  fromAliasSet records, on each
+// regexAlias produced, whether entries came from an alias-set rather than
+// the flat map, so sortRegexAliases can give alias-sets the same precedence
+// over flat entries that namedAliases already has.  It's shared by
+// shortNameAliasConf.parseAndValidate and V2RegistriesConf's equivalent so
+// that both config formats' [aliases] tables are parsed identically.
+func populateAliases(namedAliases map[string]alias, regexAliases *[]regexAlias, entries map[string]string, origin string, fromAliasSet bool) []error {
 	errs := []error{}
-	for name, value := range conf.Aliases {
+	for name, value := range entries {
+		if strings.HasPrefix(name, regexShortNamePrefix) {
+			pattern := strings.TrimPrefix(name, regexShortNamePrefix)
+			if err := validateShortNamePattern(name); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "error compiling regex alias %q", name))
+				continue
+			}
+			*regexAliases = append(*regexAliases, regexAlias{
+				pattern:      pattern,
+				regex:        regex,
+				value:        value,
+				configOrigin: origin,
+				fromAliasSet: fromAliasSet,
+				seq:          nextRegexAliasSeq(),
+			})
+			continue
+		}
+
 		if err := validateShortName(name); err != nil {
 			errs = append(errs, err)
 		}
@@ -258,7 +646,7 @@ func (conf *shortNameAliasConf) parseAndValidate(path string) error {
 		// config files from registries.conf.d can reset potentially
 		// malconfigured aliases.
 		if value == "" {
-			conf.namedAliases[name] = alias{nil, path}
+			namedAliases[name] = alias{nil, origin}
 			continue
 		}
 
@@ -268,9 +656,54 @@ func (conf *shortNameAliasConf) parseAndValidate(path string) error {
 			// whack-a-mole for the user.
 			errs = append(errs, err)
 		} else {
-			conf.namedAliases[name] = alias{named, path}
+			namedAliases[name] = alias{named, origin}
 		}
 	}
+	return errs
+}
+
+// populateAliasSets evaluates each of sets' EnabledWhen predicates and, for
+// those that are enabled, folds their Aliases into namedAliases/
+// regexAliases via populateAliases, tagging each entry's origin with the
+// set's name.  It's shared by shortNameAliasConf.parseAndValidate and
+// V2RegistriesConf's equivalent, since both config formats support
+// [[alias-set]].
+func populateAliasSets(namedAliases map[string]alias, regexAliases *[]regexAlias, sets []aliasSet, path string) []error {
+	errs := []error{}
+	for _, set := range sets {
+		enabled, err := set.EnabledWhen.evaluate()
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "error evaluating enabled-when for alias-set %q", set.Name))
+			continue
+		}
+		if !enabled {
+			continue
+		}
+		origin := fmt.Sprintf("resolved via alias-set %q in %s", set.Name, path)
+		errs = append(errs, populateAliases(namedAliases, regexAliases, set.Aliases, origin, true)...)
+	}
+	return errs
+}
+
+// parseAndValidate parses and validates all entries in conf.Aliases and
+// conf.AliasSets and stores the results in conf.namedAliases.  Entries whose
+// left-hand side carries the "re:" prefix are compiled and stored in
+// conf.regexAliases instead.  Scoped [[alias-set]] entries are evaluated
+// after the flat [aliases] map so that an enabled set overrides a
+// same-named flat entry, as documented on conf.namedAliases.
+func (conf *shortNameAliasConf) parseAndValidate(path string) error {
+	if conf.Aliases == nil {
+		conf.Aliases = make(map[string]string)
+	}
+	if conf.namedAliases == nil {
+		conf.namedAliases = make(map[string]alias)
+	}
+
+	errs := populateAliases(conf.namedAliases, &conf.regexAliases, conf.Aliases, path, false)
+	errs = append(errs, populateAliasSets(conf.namedAliases, &conf.regexAliases, conf.AliasSets, path)...)
+
+	sortRegexAliases(conf.regexAliases)
+
 	var err error // nil if no errors
 	for _, e := range errs {
 		if err == nil {
@@ -282,6 +715,11 @@ func (conf *shortNameAliasConf) parseAndValidate(path string) error {
 	return err
 }
 
+// loadShortNameAliasConf decodes confPath.  By the time it's called, any
+// journal left behind by an interrupted write has already been replayed by
+// recoverShortNameAliasConf (invoked via shortNameAliasesConfPathAndLock
+// before callers obtain confPath in the first place), so confPath itself is
+// always either the old or the new complete config, never a truncated one.
 func loadShortNameAliasConf(confPath string) (*shortNameAliasConf, error) {
 	conf := shortNameAliasConf{}
 
@@ -300,6 +738,20 @@ func loadShortNameAliasConf(confPath string) (*shortNameAliasConf, error) {
 	return &conf, nil
 }
 
+// recoverOnceByPath and recoverErrByPath ensure recoverShortNameAliasConf
+// runs at most once per distinct confPath per process: it only ever has
+// work to do immediately after a crash, so there's no point repeating it on
+// every call.  They're keyed by confPath, rather than being one
+// process-wide sync.Once, because ctx.UserShortNameAliasConfPath lets
+// different callers (and, in this package's own tests, different test
+// cases) point at different confPaths in the same process; a single global
+// Once would only ever recover whichever path was requested first.
+var (
+	recoverOnceMutex  sync.Mutex
+	recoverOnceByPath = map[string]*sync.Once{}
+	recoverErrByPath  = map[string]error{}
+)
+
 func shortNameAliasesConfPathAndLock(ctx *types.SystemContext) (string, lockfile.Locker, error) {
 	shortNameAliasesConfPath, err := shortNameAliasesConfPath(ctx)
 	if err != nil {
@@ -312,5 +764,33 @@ func shortNameAliasesConfPathAndLock(ctx *types.SystemContext) (string, lockfile
 
 	lockPath := shortNameAliasesConfPath + ".lock"
 	locker, err := lockfile.GetLockfile(lockPath)
-	return shortNameAliasesConfPath, locker, err
+	if err != nil {
+		return "", nil, err
+	}
+
+	recoverOnceMutex.Lock()
+	once, ok := recoverOnceByPath[shortNameAliasesConfPath]
+	if !ok {
+		once = &sync.Once{}
+		recoverOnceByPath[shortNameAliasesConfPath] = once
+	}
+	recoverOnceMutex.Unlock()
+
+	once.Do(func() {
+		locker.Lock()
+		defer locker.Unlock()
+		err := recoverShortNameAliasConf(shortNameAliasesConfPath)
+		recoverOnceMutex.Lock()
+		recoverErrByPath[shortNameAliasesConfPath] = err
+		recoverOnceMutex.Unlock()
+	})
+
+	recoverOnceMutex.Lock()
+	recoverErr := recoverErrByPath[shortNameAliasesConfPath]
+	recoverOnceMutex.Unlock()
+	if recoverErr != nil {
+		return "", nil, errors.Wrap(recoverErr, "error recovering from an interrupted short-name-aliases.conf write")
+	}
+
+	return shortNameAliasesConfPath, locker, nil
 }
@@ -0,0 +1,188 @@
+package sysregistriesv2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// faultAfterStep wraps defaultFileOps and returns injErr from the n-th
+// fileOps call onwards (1-indexed), simulating a process crash partway
+// through writeShortNameAliasConf.
+type faultAfterStep struct {
+	defaultFileOps
+	n      int
+	calls  int
+	injErr error
+}
+
+func (f *faultAfterStep) step() error {
+	f.calls++
+	if f.calls >= f.n {
+		return f.injErr
+	}
+	return nil
+}
+
+func (f *faultAfterStep) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := f.step(); err != nil {
+		return err
+	}
+	return f.defaultFileOps.WriteFile(path, data, perm)
+}
+
+func (f *faultAfterStep) Create(path string) (*os.File, error) {
+	if err := f.step(); err != nil {
+		return nil, err
+	}
+	return f.defaultFileOps.Create(path)
+}
+
+func (f *faultAfterStep) Sync(file *os.File) error {
+	if err := f.step(); err != nil {
+		return err
+	}
+	return f.defaultFileOps.Sync(file)
+}
+
+func (f *faultAfterStep) SyncDir(dir string) error {
+	if err := f.step(); err != nil {
+		return err
+	}
+	return f.defaultFileOps.SyncDir(dir)
+}
+
+func (f *faultAfterStep) Rename(oldpath, newpath string) error {
+	if err := f.step(); err != nil {
+		return err
+	}
+	return f.defaultFileOps.Rename(oldpath, newpath)
+}
+
+func (f *faultAfterStep) Remove(path string) error {
+	if err := f.step(); err != nil {
+		return err
+	}
+	return f.defaultFileOps.Remove(path)
+}
+
+// withFaultAfterStep installs a fault-injecting fileOps for the duration of
+// the test and restores currentFileOps afterwards.
+func withFaultAfterStep(t *testing.T, n int, injErr error) *faultAfterStep {
+	fault := &faultAfterStep{n: n, injErr: injErr}
+	old := currentFileOps
+	currentFileOps = fault
+	t.Cleanup(func() { currentFileOps = old })
+	return fault
+}
+
+func TestWriteShortNameAliasConfCrashBeforeSyncLeavesOriginalIntact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	original := []byte("[aliases]\n  \"foo\" = \"example.com/foo\"\n")
+	require.NoError(t, ioutil.WriteFile(confPath, original, 0600))
+
+	// Fail on the 2nd fileOps call, i.e. right after the journal is
+	// written but before the temporary file is fully synced.
+	withFaultAfterStep(t, 2, os.ErrClosed)
+
+	conf := &shortNameAliasConf{Aliases: map[string]string{"bar": "example.com/bar"}}
+	err = writeShortNameAliasConf(confPath, conf)
+	require.Error(t, err)
+
+	// The original file must be untouched: it was never opened for
+	// writing by the new strategy.
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+
+	// recoverShortNameAliasConf must clean up the leftover journal/tmp
+	// so a subsequent write isn't confused by them.
+	currentFileOps = defaultFileOps{}
+	require.NoError(t, recoverShortNameAliasConf(confPath))
+	assert.NoFileExists(t, confPath+journalSuffix)
+	assert.NoFileExists(t, confPath+tmpSuffix)
+}
+
+func TestRecoverShortNameAliasConfCompletesInterruptedRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte("[aliases]\n  \"foo\" = \"example.com/foo\"\n"), 0600))
+
+	// Simulate a crash that happened after the tmp file was fully
+	// written and synced, but before (or during) the rename: leave both
+	// a journal and a complete tmp file (including the completion
+	// marker written right after Encode) behind.
+	newContent := []byte("[aliases]\n  \"bar\" = \"example.com/bar\"\n" + tmpCompletionMarker)
+	require.NoError(t, ioutil.WriteFile(confPath+tmpSuffix, newContent, 0600))
+	require.NoError(t, ioutil.WriteFile(confPath+journalSuffix, []byte("write in progress\n"), 0600))
+
+	require.NoError(t, recoverShortNameAliasConf(confPath))
+
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, newContent, data)
+	assert.NoFileExists(t, confPath+journalSuffix)
+	assert.NoFileExists(t, confPath+tmpSuffix)
+}
+
+func TestRecoverShortNameAliasConfDiscardsPartialTmp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	original := []byte("[aliases]\n  \"foo\" = \"example.com/foo\"\n")
+	require.NoError(t, ioutil.WriteFile(confPath, original, 0600))
+
+	// A crash before the tmp file was fully written: it's present but
+	// malformed TOML.
+	require.NoError(t, ioutil.WriteFile(confPath+tmpSuffix, []byte("not valid toml [[["), 0600))
+	require.NoError(t, ioutil.WriteFile(confPath+journalSuffix, []byte("write in progress\n"), 0600))
+
+	require.NoError(t, recoverShortNameAliasConf(confPath))
+
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+	assert.NoFileExists(t, confPath+journalSuffix)
+	assert.NoFileExists(t, confPath+tmpSuffix)
+}
+
+func TestRecoverShortNameAliasConfDiscardsTruncatedButParseableTmp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	original := []byte("[aliases]\n  \"foo\" = \"example.com/foo\"\n")
+	require.NoError(t, ioutil.WriteFile(confPath, original, 0600))
+
+	// A crash mid-Encode: the tmp file only got some of the new
+	// aliases' key/value lines written before being killed, so it's a
+	// syntactically valid, parseable TOML document -- it's just missing
+	// both "baz" and the completion marker.  Relying on "does it parse"
+	// alone would wrongly treat this as a finished write and rename it
+	// in, silently losing "baz".
+	truncated := []byte("[aliases]\n  \"bar\" = \"example.com/bar\"\n")
+	require.NoError(t, ioutil.WriteFile(confPath+tmpSuffix, truncated, 0600))
+	require.NoError(t, ioutil.WriteFile(confPath+journalSuffix, []byte("write in progress\n"), 0600))
+
+	require.NoError(t, recoverShortNameAliasConf(confPath))
+
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+	assert.NoFileExists(t, confPath+journalSuffix)
+	assert.NoFileExists(t, confPath+tmpSuffix)
+}
@@ -0,0 +1,167 @@
+package sysregistriesv2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRegexAliasExpandsCaptures(t *testing.T) {
+	conf := &shortNameAliasConf{
+		Aliases: map[string]string{
+			"re:^ubi(8|9)/(.*)$": "registry.example.com/ubi$1/$2",
+			"re:^foo/(.*)$":      "registry.example.com/foo/$1",
+		},
+	}
+	require.NoError(t, conf.parseAndValidate("test.conf"))
+
+	tests := []struct {
+		name     string
+		expected string
+		matched  bool
+	}{
+		{"ubi8/nodejs", "registry.example.com/ubi8/nodejs", true},
+		{"ubi9/python", "registry.example.com/ubi9/python", true},
+		{"foo/bar", "registry.example.com/foo/bar", true},
+		{"unrelated/image", "", false},
+	}
+
+	for _, tc := range tests {
+		named, origin, resolved, err := resolveRegexAlias(conf.regexAliases, tc.name)
+		require.NoError(t, err)
+		assert.Equal(t, tc.matched, resolved, tc.name)
+		if tc.matched {
+			assert.Equal(t, tc.expected, named.String(), tc.name)
+			assert.Equal(t, "test.conf", origin, tc.name)
+		}
+	}
+}
+
+func TestResolveRegexAliasLongestPatternWins(t *testing.T) {
+	conf := &shortNameAliasConf{
+		Aliases: map[string]string{
+			"re:^ubi/(.*)$":       "registry.generic.example.com/ubi/$1",
+			"re:^ubi/nodejs(.*)$": "registry.specific.example.com/nodejs$1",
+		},
+	}
+	require.NoError(t, conf.parseAndValidate("test.conf"))
+
+	named, _, resolved, err := resolveRegexAlias(conf.regexAliases, "ubi/nodejs16")
+	require.NoError(t, err)
+	require.True(t, resolved)
+	assert.Equal(t, "registry.specific.example.com/nodejs16", named.String())
+}
+
+func TestValidateShortNamePattern(t *testing.T) {
+	assert.NoError(t, validateShortNamePattern("re:^foo/(.*)$"))
+	assert.Error(t, validateShortNamePattern("foo/(.*)$"))
+	assert.Error(t, validateShortNamePattern("re:"))
+}
+
+func TestAliasSetOverridesFlatAlias(t *testing.T) {
+	conf := &shortNameAliasConf{
+		Aliases: map[string]string{
+			"foo":           "registry.example.com/foo",
+			"re:^foo/(.*)$": "registry.example.com/foo/$1",
+		},
+		AliasSets: []aliasSet{
+			{
+				Name:        "prod",
+				EnabledWhen: aliasSetPredicate{Env: "SHORTNAMES_TEST_ENV=prod"},
+				Aliases: map[string]string{
+					"foo":           "registry.prod.example.com/foo",
+					"re:^foo/(.*)$": "registry.prod.example.com/foo/$1",
+				},
+			},
+		},
+	}
+
+	os.Unsetenv("SHORTNAMES_TEST_ENV")
+	require.NoError(t, conf.parseAndValidate("test.conf"))
+	al, ok := conf.namedAliases["foo"]
+	require.True(t, ok)
+	assert.Equal(t, "registry.example.com/foo", al.value.String())
+
+	named, origin, resolved, err := resolveRegexAlias(conf.regexAliases, "foo/bar")
+	require.NoError(t, err)
+	require.True(t, resolved)
+	assert.Equal(t, "registry.example.com/foo/bar", named.String())
+	assert.Equal(t, "test.conf", origin)
+
+	require.NoError(t, os.Setenv("SHORTNAMES_TEST_ENV", "prod"))
+	defer os.Unsetenv("SHORTNAMES_TEST_ENV")
+
+	conf.namedAliases = nil
+	conf.regexAliases = nil
+	require.NoError(t, conf.parseAndValidate("test.conf"))
+	al, ok = conf.namedAliases["foo"]
+	require.True(t, ok)
+	assert.Equal(t, "registry.prod.example.com/foo", al.value.String())
+	assert.Contains(t, al.configOrigin, `alias-set "prod"`)
+
+	// The same alias-set-over-flat-map precedence must hold for
+	// same-length regex aliases, per sortRegexAliases' fromAliasSet
+	// tiebreak.
+	named, origin, resolved, err = resolveRegexAlias(conf.regexAliases, "foo/bar")
+	require.NoError(t, err)
+	require.True(t, resolved)
+	assert.Equal(t, "registry.prod.example.com/foo/bar", named.String())
+	assert.Contains(t, origin, `alias-set "prod"`)
+}
+
+func TestAddShortNameAliasInScopeWritesToNamedAliasSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	ctx := &types.SystemContext{UserShortNameAliasConfPath: confPath}
+
+	require.NoError(t, AddShortNameAliasInScope(ctx, "foo", "registry.prod.example.com/foo", "prod"))
+
+	conf, err := loadShortNameAliasConf(confPath)
+	require.NoError(t, err)
+	require.Len(t, conf.AliasSets, 1)
+	assert.Equal(t, "prod", conf.AliasSets[0].Name)
+	assert.Equal(t, "registry.prod.example.com/foo", conf.AliasSets[0].Aliases["foo"])
+
+	// The default, unscoped [aliases] table must be untouched.
+	assert.NotContains(t, conf.Aliases, "foo")
+}
+
+func TestAddShortNameAliasInScopeAgainstAliaslessExistingSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "short-name-aliases.conf")
+	// A hand-written [[alias-set]] with a name but no "aliases"
+	// sub-table: TOML decoding leaves AliasSets[0].Aliases nil.
+	require.NoError(t, ioutil.WriteFile(confPath, []byte("[[alias-set]]\n  name = \"prod\"\n"), 0600))
+
+	ctx := &types.SystemContext{UserShortNameAliasConfPath: confPath}
+	require.NoError(t, AddShortNameAliasInScope(ctx, "foo", "registry.prod.example.com/foo", "prod"))
+
+	conf, err := loadShortNameAliasConf(confPath)
+	require.NoError(t, err)
+	require.Len(t, conf.AliasSets, 1)
+	assert.Equal(t, "prod", conf.AliasSets[0].Name)
+	assert.Equal(t, "registry.prod.example.com/foo", conf.AliasSets[0].Aliases["foo"])
+}
+
+func TestAddShortNameAliasRejectsRegexAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-name-aliases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := &types.SystemContext{UserShortNameAliasConfPath: filepath.Join(dir, "short-name-aliases.conf")}
+
+	// Regex aliases can only be set by editing registries.conf directly,
+	// not via the machine-generated short-name-aliases.conf.
+	assert.Error(t, AddShortNameAlias(ctx, "re:^foo$", "registry.example.com/foo"))
+}
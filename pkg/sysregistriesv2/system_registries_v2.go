@@ -0,0 +1,349 @@
+package sysregistriesv2
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// systemRegistriesConfPath is the path to the system-wide registries.conf.
+const systemRegistriesConfPath = "/etc/containers/registries.conf"
+
+// systemRegistriesConfDirPath is the path to the directory of drop-in
+// registries.conf.d files that are merged on top of systemRegistriesConfPath.
+const systemRegistriesConfDirPath = "/etc/containers/registries.conf.d"
+
+// Endpoint describes a remote location of a registry, either the primary
+// location of a Registry or one of its mirrors.
+type Endpoint struct {
+	// The endpoint's remote location.
+	Location string `toml:"location,omitempty"`
+	// If true, certificate verification is skipped for this endpoint.
+	Insecure bool `toml:"insecure,omitempty"`
+	// If true, this endpoint is only consulted for digest-based pulls;
+	// tag-based references fall through to the registry's other
+	// mirrors or its primary Location instead.  Only meaningful on a
+	// mirror, not on a registry's own Endpoint.
+	MirrorByDigestOnly bool `toml:"mirror-by-digest-only,omitempty"`
+}
+
+// Registry represents a registry configured in registries.conf along with
+// the mirrors pulls from it may be redirected to.
+type Registry struct {
+	Endpoint
+	// The registry's mirrors, tried in order before falling back to
+	// Location.
+	Mirrors []Endpoint `toml:"mirror,omitempty"`
+	// If true, this registry is blocked and must not be used for pulls.
+	Blocked bool `toml:"blocked,omitempty"`
+	// The registry this block applies to.  PullSourcesFromReference
+	// matches it by exact equality against a reference's domain, so a
+	// prefix that includes a repository path never matches.  Defaults to
+	// Location (see postProcessRegistries).
+	Prefix string `toml:"prefix,omitempty"`
+}
+
+// V2RegistriesConf is the data struct used to (de)serialize
+// registries.conf, after merging any registries.conf.d drop-ins.
+type V2RegistriesConf struct {
+	Registries []Registry `toml:"registry"`
+
+	// An independently configured, ordered list of registries to
+	// consult for unqualified (short) names.  It is kept separate from
+	// Registries because a registry may appear here without a matching
+	// [[registry]] block, and vice versa.
+	UnqualifiedSearchRegistries []string `toml:"unqualified-search-registries"`
+
+	// If true, this config (loaded from a registries.conf.d drop-in)
+	// explicitly set unqualified-search-registries to an empty list and
+	// should reset, rather than append to, whatever was merged in before
+	// it.  Derived in decodeConfig; never itself (de)serialized.
+	resetUnqualifiedSearchRegistries bool
+
+	ShortNameMode string `toml:"short-name-mode,omitempty"`
+
+	// A map for aliasing short names to their fully-qualified image
+	// reference counterparts, same syntax (including "re:" regex
+	// entries) as short-name-aliases.conf's [aliases] table.  It has
+	// lower precedence than short-name-aliases.conf: see
+	// ResolveShortNameAlias.
+	Aliases map[string]string `toml:"aliases"`
+
+	// Named, conditionally-enabled groups of aliases; see aliasSet.
+	AliasSets []aliasSet `toml:"alias-set"`
+
+	// Populated from Aliases and AliasSets by parseAndValidateAliases.
+	// Note that an alias value may be nil iff it's set as an empty
+	// string in the config.
+	namedAliases map[string]alias
+	regexAliases []regexAlias
+}
+
+// decodeConfig decodes the registries.conf-formatted file at path into a
+// fresh V2RegistriesConf, additionally recording whether
+// unqualified-search-registries was explicitly set to an empty list (as
+// opposed to merely defaulting to its zero value) so that mergeConfig can
+// tell a reset apart from "not mentioned" or from a list to append.
+func decodeConfig(path string) (*V2RegistriesConf, error) {
+	conf := V2RegistriesConf{}
+	meta, err := toml.DecodeFile(path, &conf)
+	if err != nil {
+		return nil, err
+	}
+	conf.resetUnqualifiedSearchRegistries = meta.IsDefined("unqualified-search-registries") &&
+		len(conf.UnqualifiedSearchRegistries) == 0
+	if err := conf.parseAndValidateAliases(path); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// parseAndValidateAliases parses and validates conf.Aliases and
+// conf.AliasSets into conf.namedAliases/conf.regexAliases, the same way
+// shortNameAliasConf.parseAndValidate does for short-name-aliases.conf.
+func (conf *V2RegistriesConf) parseAndValidateAliases(path string) error {
+	if conf.Aliases == nil {
+		conf.Aliases = make(map[string]string)
+	}
+	if conf.namedAliases == nil {
+		conf.namedAliases = make(map[string]alias)
+	}
+
+	errs := populateAliases(conf.namedAliases, &conf.regexAliases, conf.Aliases, path, false)
+	errs = append(errs, populateAliasSets(conf.namedAliases, &conf.regexAliases, conf.AliasSets, path)...)
+	sortRegexAliases(conf.regexAliases)
+
+	var err error // nil if no errors
+	for _, e := range errs {
+		if err == nil {
+			err = e
+		} else {
+			err = errors.Wrapf(err, "%v\n", e)
+		}
+	}
+	return err
+}
+
+// configCacheKey identifies one (registries.conf, registries.conf.d)
+// resolution, i.e. exactly the two inputs getConfig's result depends on.
+type configCacheKey struct {
+	path      string
+	dropInDir string
+}
+
+var (
+	configMutex sync.Mutex
+	configCache = map[configCacheKey]*V2RegistriesConf{}
+)
+
+// getConfig returns the cached, fully merged V2RegistriesConf for ctx's
+// (path, drop-in directory) pair, loading and merging them the first time
+// that pair is requested in a process.  It's keyed per pair, rather than
+// being a single cached value, so that callers (including tests in this
+// very package) using distinct ctx.SystemRegistriesConfPath values don't
+// silently share one another's cached config.
+//
+// Note: ctx.SystemRegistriesConfPath, if set, overrides
+// systemRegistriesConfPath, mirroring how ctx.UserShortNameAliasConfPath
+// overrides userShortNamesFile in shortnames.go.
+func getConfig(ctx *types.SystemContext) (*V2RegistriesConf, error) {
+	path := systemRegistriesConfPath
+	if ctx != nil && len(ctx.SystemRegistriesConfPath) > 0 {
+		path = ctx.SystemRegistriesConfPath
+	}
+	dropInDir := systemRegistriesConfDirPath
+	if ctx != nil && len(ctx.SystemRegistriesConfDirPath) > 0 {
+		dropInDir = ctx.SystemRegistriesConfDirPath
+	}
+	key := configCacheKey{path: path, dropInDir: dropInDir}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if cached, ok := configCache[key]; ok {
+		return cached, nil
+	}
+
+	conf, err := decodeConfig(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "error loading registries config %q", path)
+	}
+	if err != nil {
+		// It's okay for the system-wide registries.conf not to exist.
+		conf = &V2RegistriesConf{}
+		if err := conf.parseAndValidateAliases(path); err != nil {
+			return nil, err
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dropInDir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dropIn := range matches {
+		dropInConf, err := decodeConfig(dropIn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading registries config drop-in %q", dropIn)
+		}
+		mergeConfig(conf, dropInConf)
+	}
+
+	if err := postProcessRegistries(conf); err != nil {
+		return nil, err
+	}
+
+	configCache[key] = conf
+	return conf, nil
+}
+
+// mergeConfig merges dropIn on top of base, mutating base in place.  Lists
+// of registries are appended; UnqualifiedSearchRegistries is appended to
+// unless dropIn explicitly resets it (i.e. sets it to an empty list).
+func mergeConfig(base, dropIn *V2RegistriesConf) {
+	base.Registries = append(base.Registries, dropIn.Registries...)
+
+	if dropIn.resetUnqualifiedSearchRegistries {
+		// An explicit `unqualified-search-registries = []` in a
+		// drop-in resets, rather than appends to, whatever was merged
+		// in before it.
+		base.UnqualifiedSearchRegistries = append([]string{}, dropIn.UnqualifiedSearchRegistries...)
+	} else {
+		base.UnqualifiedSearchRegistries = append(base.UnqualifiedSearchRegistries, dropIn.UnqualifiedSearchRegistries...)
+	}
+
+	// dropIn's [aliases]/[[alias-set]] were already parsed (by
+	// decodeConfig, against dropIn's own path) into its namedAliases/
+	// regexAliases; a drop-in's alias overrides a same-named one from a
+	// config merged in before it.  For regex aliases that is the
+	// identical-pattern rule of sortRegexAliases, which applies after its
+	// alias-set-before-flat rule: an alias-set pattern merged in earlier
+	// still wins over the same pattern in a drop-in's flat [aliases].
+	for name, al := range dropIn.namedAliases {
+		base.namedAliases[name] = al
+	}
+	base.regexAliases = append(base.regexAliases, dropIn.regexAliases...)
+	sortRegexAliases(base.regexAliases)
+}
+
+// postProcessRegistries validates the merged configuration and rejects
+// impossible setups, such as a registry whose only way to serve tag-based
+// pulls is a mirror marked mirror-by-digest-only.
+func postProcessRegistries(conf *V2RegistriesConf) error {
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+
+		// A [[registry]] block with no explicit prefix applies to its
+		// own Location; defaulting it here (rather than special-casing
+		// an empty Prefix as "matches everything" in
+		// PullSourcesFromReference) keeps the match in that function a
+		// plain, unconditional equality check.
+		if reg.Prefix == "" {
+			reg.Prefix = reg.Location
+		}
+
+		hasTagCapableFallback := reg.Location != ""
+		if !hasTagCapableFallback {
+			for _, mirror := range reg.Mirrors {
+				if !mirror.MirrorByDigestOnly {
+					hasTagCapableFallback = true
+					break
+				}
+			}
+		}
+
+		hasDigestOnlyMirror := false
+		for _, mirror := range reg.Mirrors {
+			if mirror.MirrorByDigestOnly {
+				hasDigestOnlyMirror = true
+				break
+			}
+		}
+
+		if hasDigestOnlyMirror && !hasTagCapableFallback {
+			return errors.Errorf("registry %q has a mirror-by-digest-only mirror but no other mirror or primary location to fall back to for tag-based pulls", reg.Prefix)
+		}
+	}
+	return nil
+}
+
+// UnqualifiedSearchRegistries returns the ordered, independently configured
+// list of registries to search for unqualified (short) names.
+func UnqualifiedSearchRegistries(ctx *types.SystemContext) ([]string, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return config.UnqualifiedSearchRegistries, nil
+}
+
+// ShortNameMode returns the configured types.ShortNameMode, falling back to
+// defaultShortNameMode if registries.conf doesn't set one.
+func ShortNameMode(ctx *types.SystemContext) (types.ShortNameMode, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return types.ShortNameModeInvalid, err
+	}
+	switch config.ShortNameMode {
+	case "disabled":
+		return types.ShortNameModeDisabled, nil
+	case "enforcing":
+		return types.ShortNameModeEnforcing, nil
+	case "permissive":
+		return types.ShortNameModePermissive, nil
+	case "interactive":
+		return types.ShortNameModeInteractive, nil
+	case "":
+		return defaultShortNameMode, nil
+	default:
+		return types.ShortNameModeInvalid, errors.Errorf("invalid short-name-mode %q", config.ShortNameMode)
+	}
+}
+
+// pullSourcesFromRegistry returns the ordered list of endpoints that a pull
+// of ref should be attempted against: the registry's mirrors (skipping
+// those marked mirror-by-digest-only unless ref carries a digest), followed
+// by the registry's own Location. reg.Location may be empty -- postProcessRegistries
+// allows a registry with no primary Location as long as it has a non-digest-only
+// mirror fallback -- so the trailing Endpoint is only appended when there is an
+// actual Location to pull from.
+func pullSourcesFromRegistry(reg *Registry, ref reference.Named) []Endpoint {
+	_, isDigested := ref.(reference.Digested)
+
+	var endpoints []Endpoint
+	for _, mirror := range reg.Mirrors {
+		if mirror.MirrorByDigestOnly && !isDigested {
+			continue
+		}
+		endpoints = append(endpoints, mirror)
+	}
+	if reg.Location != "" {
+		endpoints = append(endpoints, reg.Endpoint)
+	}
+	return endpoints
+}
+
+// PullSourcesFromReference returns the ordered list of endpoints that
+// should be tried, in order, to pull ref.
+func PullSourcesFromReference(ctx *types.SystemContext, ref reference.Named) ([]Endpoint, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		// reg.Prefix is never empty here: postProcessRegistries
+		// defaults it to reg.Location.  A registry with neither set
+		// has nothing to match against and is correctly skipped.
+		if reg.Prefix != reference.Domain(ref) {
+			continue
+		}
+		return pullSourcesFromRegistry(reg, ref), nil
+	}
+
+	return nil, nil
+}
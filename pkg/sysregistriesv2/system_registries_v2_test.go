@@ -0,0 +1,208 @@
+package sysregistriesv2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTOML is a small test helper that encodes v and writes it to a file
+// under dir, returning the file's path.
+func writeTOML(t *testing.T, dir, name string, v interface{}) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, toml.NewEncoder(f).Encode(v))
+	return path
+}
+
+func TestMergeConfigAppendsUnqualifiedSearchRegistries(t *testing.T) {
+	base := &V2RegistriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}}
+	dropIn := &V2RegistriesConf{UnqualifiedSearchRegistries: []string{"quay.io"}}
+
+	mergeConfig(base, dropIn)
+
+	assert.Equal(t, []string{"docker.io", "quay.io"}, base.UnqualifiedSearchRegistries)
+}
+
+func TestMergeConfigResetsUnqualifiedSearchRegistries(t *testing.T) {
+	base := &V2RegistriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}}
+	dropIn := &V2RegistriesConf{
+		UnqualifiedSearchRegistries:      []string{"quay.io"},
+		resetUnqualifiedSearchRegistries: true,
+	}
+
+	mergeConfig(base, dropIn)
+
+	assert.Equal(t, []string{"quay.io"}, base.UnqualifiedSearchRegistries)
+}
+
+func TestDecodeConfigDetectsExplicitEmptyReset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries-conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// An explicit, empty list must still be detected as "set".
+	path := filepath.Join(dir, "reset.conf")
+	require.NoError(t, ioutil.WriteFile(path, []byte("unqualified-search-registries = []\n"), 0600))
+
+	conf, err := decodeConfig(path)
+	require.NoError(t, err)
+	assert.True(t, conf.resetUnqualifiedSearchRegistries)
+	assert.Empty(t, conf.UnqualifiedSearchRegistries)
+
+	// Omitting the key entirely must not be detected as "set".
+	path = filepath.Join(dir, "noop.conf")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[[registry]]
+prefix = "example.com"
+`), 0600))
+
+	conf, err = decodeConfig(path)
+	require.NoError(t, err)
+	assert.False(t, conf.resetUnqualifiedSearchRegistries)
+}
+
+func TestMergeConfigRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries-conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	basePath := writeTOML(t, dir, "base.conf", &V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"docker.io"},
+	})
+	dropInPath := filepath.Join(dir, "dropin.conf")
+	require.NoError(t, ioutil.WriteFile(dropInPath, []byte("unqualified-search-registries = []\n"), 0600))
+
+	base, err := decodeConfig(basePath)
+	require.NoError(t, err)
+	dropIn, err := decodeConfig(dropInPath)
+	require.NoError(t, err)
+
+	mergeConfig(base, dropIn)
+	assert.Empty(t, base.UnqualifiedSearchRegistries)
+}
+
+func TestPullSourcesFromRegistrySkipsDigestOnlyMirrorsForTags(t *testing.T) {
+	reg := &Registry{
+		Endpoint: Endpoint{Location: "example.com/repo"},
+		Mirrors: []Endpoint{
+			{Location: "mirror.example.com/repo", MirrorByDigestOnly: true},
+			{Location: "mirror2.example.com/repo"},
+		},
+	}
+
+	tagRef := &fakeNamed{}
+	sources := pullSourcesFromRegistry(reg, tagRef)
+	require.Len(t, sources, 2)
+	assert.Equal(t, "mirror2.example.com/repo", sources[0].Location)
+	assert.Equal(t, "example.com/repo", sources[1].Location)
+}
+
+// fakeNamed is a minimal reference.Named that is neither Tagged nor
+// Digested, i.e. it represents a tag-based (name-only) reference.  A zero
+// fakeNamed defaults to "example.com/repo", matching its original fixed
+// behavior.
+type fakeNamed struct {
+	repo string
+}
+
+func (f fakeNamed) String() string { return f.Name() }
+func (f fakeNamed) Name() string {
+	if f.repo == "" {
+		return "example.com/repo"
+	}
+	return f.repo
+}
+
+func TestPostProcessRegistriesAllowsDigestOnlyMirrorWithRegularMirrorFallback(t *testing.T) {
+	// No primary Location, but a second, non-digest-only mirror can
+	// still serve tag-based pulls: this must be accepted.
+	conf := &V2RegistriesConf{
+		Registries: []Registry{
+			{
+				Prefix: "example.com",
+				Mirrors: []Endpoint{
+					{Location: "digest-only.example.com", MirrorByDigestOnly: true},
+					{Location: "regular.example.com"},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, postProcessRegistries(conf))
+}
+
+func TestPostProcessRegistriesRejectsDigestOnlyMirrorWithNoFallback(t *testing.T) {
+	// No primary Location and no non-digest-only mirror: there is no way
+	// to serve a tag-based pull, so this must be rejected.
+	conf := &V2RegistriesConf{
+		Registries: []Registry{
+			{
+				Prefix: "example.com",
+				Mirrors: []Endpoint{
+					{Location: "digest-only.example.com", MirrorByDigestOnly: true},
+				},
+			},
+		},
+	}
+
+	assert.Error(t, postProcessRegistries(conf))
+}
+
+// withConfigCache installs conf as the process-wide getConfig cache for the
+// duration of the test, restoring whatever was cached before.  It lets
+// PullSourcesFromReference be exercised directly, rather than only its
+// unexported pullSourcesFromRegistry helper, without going through
+// getConfig's filesystem loading.
+func withConfigCache(t *testing.T, conf *V2RegistriesConf) {
+	key := configCacheKey{path: systemRegistriesConfPath, dropInDir: systemRegistriesConfDirPath}
+	configMutex.Lock()
+	old, hadOld := configCache[key]
+	configCache[key] = conf
+	configMutex.Unlock()
+	t.Cleanup(func() {
+		configMutex.Lock()
+		if hadOld {
+			configCache[key] = old
+		} else {
+			delete(configCache, key)
+		}
+		configMutex.Unlock()
+	})
+}
+
+func TestPullSourcesFromReferenceMatchesExactPrefixOnly(t *testing.T) {
+	conf := &V2RegistriesConf{
+		Registries: []Registry{
+			// No explicit prefix: postProcessRegistries must default
+			// it to Location ("docker.io"), not treat it as a
+			// catch-all for every domain.
+			{Endpoint: Endpoint{Location: "docker.io"}},
+			{Prefix: "example.com", Endpoint: Endpoint{Location: "mirror.example.com"}},
+		},
+	}
+	require.NoError(t, postProcessRegistries(conf))
+	withConfigCache(t, conf)
+
+	sources, err := PullSourcesFromReference(nil, fakeNamed{repo: "example.com/repo"})
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, "mirror.example.com", sources[0].Location)
+
+	sources, err = PullSourcesFromReference(nil, fakeNamed{repo: "docker.io/repo"})
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, "docker.io", sources[0].Location)
+
+	// A domain matching neither registry's (possibly defaulted) prefix
+	// must not be hijacked by the prefix-less entry.
+	sources, err = PullSourcesFromReference(nil, fakeNamed{repo: "quay.io/repo"})
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+}
@@ -0,0 +1,41 @@
+package types
+
+// SystemContext carries global and "global default" configuration values
+// relevant to fulfilling container image and registries.conf requests.
+//
+// Not all fields are relevant to every call; SystemContext is kept small so
+// that changes to it don't have to touch every caller, not because every
+// field is always meaningful.
+type SystemContext struct {
+	// If not "", overrides the system's default path for registries.conf.
+	SystemRegistriesConfPath string
+	// If not "", overrides the system's default path for the
+	// registries.conf.d drop-in directory.
+	SystemRegistriesConfDirPath string
+	// If not "", overrides the default path for the machine-generated
+	// short-name-aliases.conf.
+	UserShortNameAliasConfPath string
+}
+
+// ShortNameMode defines the mode of resolution for short names, i.e.
+// image references that don't include a registry.
+type ShortNameMode int
+
+const (
+	ShortNameModeInvalid ShortNameMode = iota
+	// ShortNameModeDisabled entirely disables short-name resolution:
+	// unqualified names are rejected.
+	ShortNameModeDisabled
+	// ShortNameModeEnforcing requires every short name to resolve to an
+	// alias; unaliased short names are rejected.
+	ShortNameModeEnforcing
+	// ShortNameModePermissive resolves a short name via its alias if
+	// one is configured, and otherwise falls back to searching
+	// unqualified-search-registries.
+	ShortNameModePermissive
+	// ShortNameModeInteractive behaves like ShortNameModePermissive,
+	// except that, rather than silently searching every unqualified
+	// search registry, it prompts the user to choose (and optionally
+	// remember) one via pkg/shortnames.
+	ShortNameModeInteractive
+)